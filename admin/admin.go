@@ -0,0 +1,151 @@
+// Package admin implements the proxy's admin HTTP API: Prometheus metrics,
+// health/readiness probes, pprof profiling, and a small set of operator
+// actions (e.g. reloading the token list, or stopping/restarting the main
+// listener). It's meant to be bound to a separate address from the proxy's
+// own listeners, and left disabled unless an operator opts in.
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+)
+
+// MetricsSource is implemented by anything that can report its own runtime
+// counters, e.g. Server (connection counts) or a filter middleware
+// (per-device/per-token counts). Values are reported as Prometheus gauges.
+type MetricsSource interface {
+	Counters() map[string]float64
+}
+
+// TokenReloader is implemented by middleware that can swap in a new set of
+// valid tokens without a restart.
+type TokenReloader interface {
+	ReloadTokens() error
+}
+
+// ListenerControl is implemented by the thing admin uses to forcibly stop
+// or restart proxy's main listener, e.g. the limitedListener doServe uses
+// to enforce maxConns.
+type ListenerControl interface {
+	Stop()
+	Restart()
+}
+
+// Handler serves the admin API.
+type Handler struct {
+	mux      *http.ServeMux
+	sources  []MetricsSource
+	reloader TokenReloader
+	listener ListenerControl
+	ready    func() bool
+}
+
+// New builds an admin Handler.
+//
+//   - ready backs /readyz; nil always reports ready.
+//   - reloader, if non-nil, backs POST /reload-tokens.
+//   - listener, if non-nil, backs POST /listener/stop and /listener/restart.
+//   - sources are polled for counters on every /metrics scrape.
+func New(ready func() bool, reloader TokenReloader, listener ListenerControl, sources ...MetricsSource) *Handler {
+	h := &Handler{sources: sources, reloader: reloader, listener: listener, ready: ready}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", h.serveMetrics)
+	mux.HandleFunc("/healthz", h.serveHealthz)
+	mux.HandleFunc("/readyz", h.serveReadyz)
+	mux.HandleFunc("/reload-tokens", h.serveReloadTokens)
+	mux.HandleFunc("/listener/stop", h.serveListenerStop)
+	mux.HandleFunc("/listener/restart", h.serveListenerRestart)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	h.mux = mux
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.mux.ServeHTTP(w, req)
+}
+
+// serveMetrics renders every source's Counters() as Prometheus text-format
+// gauges. Sources are trusted to use metric names that don't collide; a
+// later source silently wins a collision, same as map assignment would.
+func (h *Handler) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	merged := make(map[string]float64)
+	for _, s := range h.sources {
+		for name, value := range s.Counters() {
+			merged[name] = value
+		}
+	}
+
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s %v\n", name, name, merged[name])
+	}
+}
+
+func (h *Handler) serveHealthz(w http.ResponseWriter, req *http.Request) {
+	fmt.Fprint(w, "ok")
+}
+
+func (h *Handler) serveReadyz(w http.ResponseWriter, req *http.Request) {
+	if h.ready != nil && !h.ready() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprint(w, "ok")
+}
+
+func (h *Handler) serveReloadTokens(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.reloader == nil {
+		http.Error(w, "token reload not supported", http.StatusNotImplemented)
+		return
+	}
+	if err := h.reloader.ReloadTokens(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, "ok")
+}
+
+func (h *Handler) serveListenerStop(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.listener == nil {
+		http.Error(w, "listener control not supported", http.StatusNotImplemented)
+		return
+	}
+	h.listener.Stop()
+	fmt.Fprint(w, "ok")
+}
+
+func (h *Handler) serveListenerRestart(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.listener == nil {
+		http.Error(w, "listener control not supported", http.StatusNotImplemented)
+		return
+	}
+	h.listener.Restart()
+	fmt.Fprint(w, "ok")
+}
@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeSource struct {
+	counters map[string]float64
+}
+
+func (f fakeSource) Counters() map[string]float64 { return f.counters }
+
+type fakeReloader struct {
+	called bool
+	err    error
+}
+
+func (f *fakeReloader) ReloadTokens() error {
+	f.called = true
+	return f.err
+}
+
+func TestServeMetricsRendersAllSources(t *testing.T) {
+	h := New(nil, nil, nil,
+		fakeSource{counters: map[string]float64{"httpproxy_a": 1}},
+		fakeSource{counters: map[string]float64{"httpproxy_b": 2}},
+	)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "httpproxy_a 1") || !strings.Contains(body, "httpproxy_b 2") {
+		t.Fatalf("metrics output missing expected gauges: %q", body)
+	}
+}
+
+func TestServeReloadTokensRequiresPost(t *testing.T) {
+	reloader := &fakeReloader{}
+	h := New(nil, reloader, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reload-tokens", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /reload-tokens = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if reloader.called {
+		t.Fatal("reloader was called despite the wrong HTTP method")
+	}
+}
+
+func TestServeReloadTokensCallsReloader(t *testing.T) {
+	reloader := &fakeReloader{}
+	h := New(nil, reloader, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload-tokens", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /reload-tokens = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !reloader.called {
+		t.Fatal("ReloadTokens was never called")
+	}
+}
+
+func TestServeReloadTokensWithoutReloaderIs501(t *testing.T) {
+	h := New(nil, nil, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload-tokens", nil))
+
+	if rec.Code != http.StatusNotImplemented {
+		t.Fatalf("POST /reload-tokens with nil reloader = %d, want %d", rec.Code, http.StatusNotImplemented)
+	}
+}
@@ -0,0 +1,271 @@
+// Package cache implements a pluggable response cache for proxied GET
+// requests. It's meant to sit between httpconnect and forward in the
+// middleware chain: a hit is served straight from the backend, and a miss
+// falls through to forward, with the backend populated asynchronously from
+// the upstream response.
+package cache
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"../utils"
+)
+
+// Backend stores and retrieves cached responses keyed by an opaque string
+// (see Key). Implementations must be safe for concurrent use.
+type Backend interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, entry *Entry)
+}
+
+// Entry is a cached response along with the validators needed to honor
+// conditional requests, expiry, and Vary.
+type Entry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Expires    time.Time
+	ETag       string
+
+	// varyValues holds, for each header named in the response's own Vary
+	// header, the value that header had on the request that produced this
+	// entry. A later request is only served this entry if its values for
+	// those headers match exactly; see varyMatches.
+	varyValues map[string]string
+}
+
+func (e *Entry) expired() bool {
+	return !e.Expires.IsZero() && time.Now().After(e.Expires)
+}
+
+// varyMatches reports whether req is equivalent, per the entry's recorded
+// Vary header, to the request that produced entry.
+func (e *Entry) varyMatches(req *http.Request) bool {
+	for name, want := range e.varyValues {
+		if req.Header.Get(name) != want {
+			return false
+		}
+	}
+	return true
+}
+
+type optSetter func(c *cachingHandler) error
+
+// Option configures a cachingHandler created by New.
+type Option optSetter
+
+// WithBackend selects the storage backend used to hold cached entries. Defaults
+// to a 1000-entry in-memory LRU when not given.
+func WithBackend(b Backend) Option {
+	return func(c *cachingHandler) error {
+		c.backend = b
+		return nil
+	}
+}
+
+// Logger sets the logger used to report cache hits/misses.
+func Logger(l *utils.TimeLogger) Option {
+	return func(c *cachingHandler) error {
+		c.log = l
+		return nil
+	}
+}
+
+type cachingHandler struct {
+	next    http.Handler
+	backend Backend
+	log     *utils.TimeLogger
+}
+
+// New creates a caching middleware that wraps next, which is invoked on
+// cache misses and for anything that isn't a cacheable GET.
+func New(next http.Handler, setters ...Option) (http.Handler, error) {
+	c := &cachingHandler{
+		next:    next,
+		backend: NewMemoryBackend(1000),
+	}
+	for _, s := range setters {
+		if err := s(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *cachingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet || !cacheable(req) {
+		c.next.ServeHTTP(w, req)
+		return
+	}
+
+	key := Key(req)
+	if entry, ok := c.backend.Get(key); ok && !entry.expired() && entry.varyMatches(req) {
+		if notModified(req, entry) {
+			w.WriteHeader(http.StatusNotModified)
+			if c.log != nil {
+				c.log.Printf("cache: 304 %s", key)
+			}
+			return
+		}
+		writeEntry(w, entry)
+		if c.log != nil {
+			c.log.Printf("cache: hit %s", key)
+		}
+		return
+	}
+
+	rec := &responseRecorder{ResponseWriter: w, header: make(http.Header)}
+	c.next.ServeHTTP(rec, req)
+
+	if entry := toEntry(rec, req); entry != nil {
+		go c.backend.Set(key, entry)
+	}
+	if c.log != nil {
+		c.log.Printf("cache: miss %s", key)
+	}
+}
+
+// cacheable reports whether req is eligible to be served from, or used to
+// populate, the cache.
+func cacheable(req *http.Request) bool {
+	return req.Header.Get("Cache-Control") != "no-cache" && req.Header.Get("Pragma") != "no-cache"
+}
+
+// Key derives the primary cache key for req from its method and URL.
+// Requests that differ only by a header named in the matching entry's Vary
+// header are disambiguated separately, at lookup time, by Entry.varyMatches
+// — not folded into this string, since the Vary header isn't known until
+// the first response for a URL comes back.
+func Key(req *http.Request) string {
+	return req.Method + " " + req.URL.String()
+}
+
+// carriesCredentials reports whether req is personalized to a specific
+// caller via credentials that a shared cache must never let leak to a
+// different caller.
+func carriesCredentials(req *http.Request) bool {
+	return req.Header.Get("Authorization") != "" || req.Header.Get("Cookie") != ""
+}
+
+// personalizesResponse reports whether header carries a Set-Cookie: the
+// origin is handing the caller a personalized session/CSRF value, which a
+// shared cache must never replay to a different caller, same as an
+// Authorization/Cookie-bearing request.
+func personalizesResponse(header http.Header) bool {
+	return header.Get("Set-Cookie") != ""
+}
+
+func notModified(req *http.Request, entry *Entry) bool {
+	inm := req.Header.Get("If-None-Match")
+	return inm != "" && entry.ETag != "" && inm == entry.ETag
+}
+
+func writeEntry(w http.ResponseWriter, entry *Entry) {
+	for k, vs := range entry.Header {
+		for _, v := range vs {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(entry.StatusCode)
+	io.Copy(w, bytes.NewReader(entry.Body))
+}
+
+// toEntry builds a cache Entry from a recorded upstream response, or
+// returns nil if the response isn't cacheable. That includes the existing
+// Cache-Control checks, and, since this cache sits in front of every
+// proxied client: any request carrying Authorization or Cookie headers, and
+// any response carrying Set-Cookie. Both are personalized to whoever
+// authenticated, and must never be handed to a different caller hitting the
+// same URL, unless the origin explicitly marked the response
+// Cache-Control: public.
+func toEntry(rec *responseRecorder, req *http.Request) *Entry {
+	if rec.statusCode != http.StatusOK {
+		return nil
+	}
+	cc := rec.header.Get("Cache-Control")
+	if cc == "no-store" || cc == "private" {
+		return nil
+	}
+	if (carriesCredentials(req) || personalizesResponse(rec.header)) && !hasDirective(cc, "public") {
+		return nil
+	}
+
+	entry := &Entry{
+		StatusCode: rec.statusCode,
+		Header:     rec.header,
+		Body:       rec.body.Bytes(),
+		ETag:       rec.header.Get("ETag"),
+		varyValues: varyValues(rec.header, req),
+	}
+	if exp := rec.header.Get("Expires"); exp != "" {
+		if t, err := http.ParseTime(exp); err == nil {
+			entry.Expires = t
+		}
+	}
+	return entry
+}
+
+// varyValues records, for each header named in respHeader's Vary header,
+// the value that header had on req, so a later Entry.varyMatches can tell
+// whether a different request is actually equivalent.
+func varyValues(respHeader http.Header, req *http.Request) map[string]string {
+	vary := respHeader.Get("Vary")
+	if vary == "" {
+		return nil
+	}
+	values := make(map[string]string)
+	for _, name := range strings.Split(vary, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "*" {
+			continue
+		}
+		values[http.CanonicalHeaderKey(name)] = req.Header.Get(name)
+	}
+	return values
+}
+
+func hasDirective(cacheControl, directive string) bool {
+	for _, d := range strings.Split(cacheControl, ",") {
+		if strings.EqualFold(strings.TrimSpace(d), directive) {
+			return true
+		}
+	}
+	return false
+}
+
+// responseRecorder captures an upstream response so it can be cached and
+// also relayed to the original client in a single pass.
+type responseRecorder struct {
+	http.ResponseWriter
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+	wroteHdr   bool
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.statusCode = code
+	r.wroteHdr = true
+	for k, vs := range r.header {
+		for _, v := range vs {
+			r.ResponseWriter.Header().Add(k, v)
+		}
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHdr {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
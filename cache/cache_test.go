@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func recordedResponse(header http.Header) *responseRecorder {
+	rec := &responseRecorder{header: make(http.Header)}
+	for k, vs := range header {
+		for _, v := range vs {
+			rec.header.Add(k, v)
+		}
+	}
+	rec.statusCode = http.StatusOK
+	return rec
+}
+
+func TestToEntryRefusesPersonalizedResponses(t *testing.T) {
+	cases := []struct {
+		name       string
+		reqHeader  http.Header
+		respHeader http.Header
+		wantNil    bool
+	}{
+		{
+			name:    "plain anonymous response is cached",
+			wantNil: false,
+		},
+		{
+			name:      "request carrying Authorization is refused",
+			reqHeader: http.Header{"Authorization": {"Bearer secret"}},
+			wantNil:   true,
+		},
+		{
+			name:      "request carrying Cookie is refused",
+			reqHeader: http.Header{"Cookie": {"session=abc"}},
+			wantNil:   true,
+		},
+		{
+			name:       "response carrying Set-Cookie is refused",
+			respHeader: http.Header{"Set-Cookie": {"session=abc"}},
+			wantNil:    true,
+		},
+		{
+			name:       "Cache-Control: public overrides a request Authorization header",
+			reqHeader:  http.Header{"Authorization": {"Bearer secret"}},
+			respHeader: http.Header{"Cache-Control": {"public"}},
+			wantNil:    false,
+		},
+		{
+			name:       "Cache-Control: public overrides a response Set-Cookie header",
+			respHeader: http.Header{"Set-Cookie": {"session=abc"}, "Cache-Control": {"public"}},
+			wantNil:    false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/me", nil)
+			for k, vs := range tc.reqHeader {
+				for _, v := range vs {
+					req.Header.Add(k, v)
+				}
+			}
+			rec := recordedResponse(tc.respHeader)
+
+			entry := toEntry(rec, req)
+			if (entry == nil) != tc.wantNil {
+				t.Fatalf("toEntry() = %v, want nil: %v", entry, tc.wantNil)
+			}
+		})
+	}
+}
+
+// TestServeHTTPDoesNotLeakAcrossClients drives two requests for the same URL
+// with different Authorization headers through ServeHTTP end-to-end, and
+// makes sure the second caller's request actually reaches next (a cache
+// miss) rather than being served the first caller's cached, personalized
+// response.
+func TestServeHTTPDoesNotLeakAcrossClients(t *testing.T) {
+	var upstreamHits int
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upstreamHits++
+		w.Write([]byte("hello " + req.Header.Get("Authorization")))
+	})
+
+	c, err := New(next)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for i, auth := range []string{"user-a-token", "user-b-token"} {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/me", nil)
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+
+		c.ServeHTTP(rec, req)
+
+		if got := rec.Body.String(); got != "hello "+auth {
+			t.Fatalf("request %d: got body %q, want %q (served another client's cached response)", i, got, "hello "+auth)
+		}
+	}
+
+	if upstreamHits != 2 {
+		t.Fatalf("next was hit %d times, want 2 (second request should not have been served from cache)", upstreamHits)
+	}
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// MemoryBackend is an in-process LRU Backend. It's the default used by New
+// when no other Backend is configured.
+type MemoryBackend struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key   string
+	entry *Entry
+}
+
+// NewMemoryBackend creates a MemoryBackend holding at most capacity entries,
+// evicting the least recently used entry once full. A capacity of 0 or less
+// means no limit: nothing is ever evicted.
+func NewMemoryBackend(capacity int) *MemoryBackend {
+	return &MemoryBackend{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (m *MemoryBackend) Get(key string) (*Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	m.ll.MoveToFront(el)
+	return el.Value.(*memoryEntry).entry, true
+}
+
+func (m *MemoryBackend) Set(key string, entry *Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[key]; ok {
+		m.ll.MoveToFront(el)
+		el.Value.(*memoryEntry).entry = entry
+		return
+	}
+
+	el := m.ll.PushFront(&memoryEntry{key: key, entry: entry})
+	m.items[key] = el
+
+	for m.capacity > 0 && m.ll.Len() > m.capacity {
+		oldest := m.ll.Back()
+		if oldest == nil {
+			break
+		}
+		m.ll.Remove(oldest)
+		delete(m.items, oldest.Value.(*memoryEntry).key)
+	}
+}
@@ -0,0 +1,137 @@
+// Package devicefilter extracts the user ID and counts requests per ID.
+// Returns a 404 to requests without the UID.  Removes the header before
+// continuing.  Nothing is attached to the request context.
+package devicefilter
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+
+	"../utils"
+)
+
+// Header is the request header devicefilter reads the device/user ID from.
+const Header = "X-Lantern-UID"
+
+// maxTrackedDevices caps how many distinct UIDs Counters keeps a per-device
+// count for. uid is client-supplied and otherwise unbounded, so without a
+// cap a caller could grow the tracking map indefinitely; once full, the
+// least-recently-seen UID is evicted to make room, same as cache's LRU
+// backend.
+const maxTrackedDevices = 10000
+
+type optSetter func(f *Filter) error
+
+// Option configures a Filter created by New.
+type Option optSetter
+
+// Logger sets the logger used to report denied requests.
+func Logger(l *utils.TimeLogger) Option {
+	return func(f *Filter) error {
+		f.log = l
+		return nil
+	}
+}
+
+// deviceCount is the per-device request count tracked in Filter.devices,
+// kept in an *list.Element so the underlying list can double as an LRU of
+// which UID to evict once maxTrackedDevices is exceeded.
+type deviceCount struct {
+	uid   string
+	count uint64
+}
+
+// Filter rejects requests without a device/user ID and keeps a per-device
+// request count for observability.
+type Filter struct {
+	next http.Handler
+	log  *utils.TimeLogger
+
+	mu      sync.Mutex
+	devices map[string]*list.Element
+	lru     *list.List
+}
+
+// New creates a Filter.
+func New(next http.Handler, setters ...Option) (*Filter, error) {
+	f := &Filter{
+		next:    next,
+		devices: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	for _, s := range setters {
+		if err := s(f); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *Filter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	uid := req.Header.Get(Header)
+	req.Header.Del(Header)
+
+	if uid == "" {
+		if f.log != nil {
+			f.log.Printf("devicefilter: missing UID from %s", req.RemoteAddr)
+		}
+		http.NotFound(w, req)
+		return
+	}
+
+	f.track(uid)
+
+	f.next.ServeHTTP(w, req)
+}
+
+func (f *Filter) track(uid string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if el, ok := f.devices[uid]; ok {
+		f.lru.MoveToFront(el)
+		el.Value.(*deviceCount).count++
+		return
+	}
+
+	el := f.lru.PushFront(&deviceCount{uid: uid, count: 1})
+	f.devices[uid] = el
+
+	for f.lru.Len() > maxTrackedDevices {
+		oldest := f.lru.Back()
+		if oldest == nil {
+			break
+		}
+		f.lru.Remove(oldest)
+		delete(f.devices, oldest.Value.(*deviceCount).uid)
+	}
+}
+
+// escapeLabelValue makes uid safe to embed in a Prometheus label value:
+// uid is client-supplied (it's the raw X-Lantern-UID header), so without
+// this a UID containing a `"` or `\` could break out of the label's quoting
+// in admin's hand-rolled metrics exposition and forge extra labels.
+func escapeLabelValue(uid string) string {
+	uid = strings.ReplaceAll(uid, `\`, `\\`)
+	uid = strings.ReplaceAll(uid, `"`, `\"`)
+	uid = strings.ReplaceAll(uid, "\n", `\n`)
+	return uid
+}
+
+// Counters implements admin.MetricsSource, publishing one gauge per device
+// seen so far, up to maxTrackedDevices. Fine for the modest number of
+// concurrently active devices a single proxy instance handles; not meant to
+// scale to fleet-wide cardinality.
+func (f *Filter) Counters() map[string]float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	counters := make(map[string]float64, len(f.devices))
+	for el := f.lru.Front(); el != nil; el = el.Next() {
+		dc := el.Value.(*deviceCount)
+		counters[`httpproxy_devicefilter_requests_total{uid="`+escapeLabelValue(dc.uid)+`"}`] = float64(dc.count)
+	}
+	return counters
+}
@@ -0,0 +1,47 @@
+package devicefilter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCountersEscapesUntrustedUID(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	f, err := New(next)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.Header.Set(Header, `evil"} httpproxy_injected_metric 1 {uid="x`)
+	f.ServeHTTP(httptest.NewRecorder(), req)
+
+	for name := range f.Counters() {
+		// Escaped quotes (\") stay inside the label value; only the two
+		// delimiting quotes may appear unescaped.
+		if strings.Count(name, `"`)-strings.Count(name, `\"`) != 2 {
+			t.Fatalf("unescaped quote broke out of the label value in metric name: %q", name)
+		}
+	}
+}
+
+func TestCountersCapsTrackedDevices(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	f, err := New(next)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for i := 0; i < maxTrackedDevices+500; i++ {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.Header.Set(Header, fmt.Sprintf("uid-%d", i))
+		f.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if got := len(f.Counters()); got > maxTrackedDevices {
+		t.Fatalf("tracked %d devices, want at most %d", got, maxTrackedDevices)
+	}
+}
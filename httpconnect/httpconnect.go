@@ -0,0 +1,117 @@
+// Package httpconnect implements the middleware that handles HTTP CONNECT,
+// hijacking the client connection and splicing it to the target, either
+// dialed directly or, when configured, through a parent HTTP CONNECT proxy.
+package httpconnect
+
+import (
+	"io"
+	"net"
+	"net/http"
+
+	"../parentproxy"
+	"../utils"
+)
+
+type optSetter func(h *Handler) error
+
+// Option configures a Handler created by New.
+type Option optSetter
+
+// Logger sets the logger used to report CONNECT tunnel errors.
+func Logger(l *utils.TimeLogger) Option {
+	return func(h *Handler) error {
+		h.log = l
+		return nil
+	}
+}
+
+// ParentProxy routes CONNECT tunnels through the given parent HTTP CONNECT
+// proxy instead of dialing the target directly, so HTTPS traffic can be
+// chained behind a corporate egress proxy.
+func ParentProxy(parent *parentproxy.URL) Option {
+	return func(h *Handler) error {
+		h.parentProxy = parent
+		return nil
+	}
+}
+
+// NoBannerHeader, when present (and non-empty) on a CONNECT request,
+// suppresses the "200 Connection Established" banner normally written to
+// the client after hijacking. Front-ends that speak a different tunnel
+// protocol but still dispatch through this handler — e.g. socks5, which
+// already sent its own protocol-specific success reply — set this so the
+// two replies don't collide on the wire.
+const NoBannerHeader = "X-Httpproxy-Raw-Tunnel"
+
+// Handler handles HTTP CONNECT requests, passing everything else to next.
+type Handler struct {
+	next        http.Handler
+	log         *utils.TimeLogger
+	parentProxy *parentproxy.URL
+}
+
+// New creates a Handler. Requests other than CONNECT are passed to next.
+func New(next http.Handler, setters ...Option) (*Handler, error) {
+	h := &Handler{next: next}
+	for _, s := range setters {
+		if err := s(h); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodConnect {
+		h.next.ServeHTTP(w, req)
+		return
+	}
+
+	targetConn, err := h.dial(req.Host)
+	if err != nil {
+		if h.log != nil {
+			h.log.Errorf("httpconnect: dial %s: %s", req.Host, err)
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer targetConn.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection doesn't support hijacking", http.StatusInternalServerError)
+		return
+	}
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer clientConn.Close()
+
+	if req.Header.Get(NoBannerHeader) == "" {
+		if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+			return
+		}
+	}
+
+	errc := make(chan error, 2)
+	go pipe(targetConn, clientConn, errc)
+	go pipe(clientConn, targetConn, errc)
+	<-errc
+}
+
+// dial opens a connection to addr, routing it through the configured
+// parent proxy's CONNECT handshake if one was set via ParentProxy, and
+// falling through to a direct dial when no parent is configured.
+func (h *Handler) dial(addr string) (net.Conn, error) {
+	if h.parentProxy == nil {
+		return net.Dial("tcp", addr)
+	}
+	return parentproxy.Dial(h.parentProxy, "tcp", addr)
+}
+
+func pipe(dst io.Writer, src io.Reader, errc chan<- error) {
+	_, err := io.Copy(dst, src)
+	errc <- err
+}
@@ -0,0 +1,78 @@
+package parentproxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// fakeParent accepts a single CONNECT request on a local listener, passes its
+// Proxy-Authorization header to checkAuth (when non-nil), and replies with
+// status.
+func fakeParent(t *testing.T, status int, checkAuth func(got string)) *URL {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		if req.Method != http.MethodConnect {
+			return
+		}
+		if checkAuth != nil {
+			checkAuth(req.Header.Get("Proxy-Authorization"))
+		}
+		fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status))
+	}()
+
+	return &url.URL{Host: listener.Addr().String()}
+}
+
+func TestDialSendsProxyAuthorization(t *testing.T) {
+	var gotAuth string
+	parent := fakeParent(t, http.StatusOK, func(got string) { gotAuth = got })
+	parent.User = url.UserPassword("alice", "secret")
+
+	conn, err := Dial(parent, "tcp", "example.com:443")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	if gotAuth == "" {
+		t.Fatal("parent never saw a Proxy-Authorization header")
+	}
+}
+
+func TestDialFailsOnNonOKResponse(t *testing.T) {
+	parent := fakeParent(t, http.StatusForbidden, nil)
+
+	if _, err := Dial(parent, "tcp", "example.com:443"); err == nil {
+		t.Fatal("Dial succeeded despite a non-200 CONNECT response from the parent")
+	}
+}
+
+func TestParseEmptyURLMeansNoParent(t *testing.T) {
+	u, err := Parse("")
+	if err != nil {
+		t.Fatalf("Parse(\"\"): %v", err)
+	}
+	if u != nil {
+		t.Fatalf("Parse(\"\") = %v, want nil", u)
+	}
+}
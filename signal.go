@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// HandleShutdownSignal blocks until SIGINT or SIGTERM is received, then
+// drains the proxy via Shutdown, falling back to an immediate Close if the
+// drain doesn't finish within drainTimeout. Intended to be called from
+// main after all of the proxy's listeners have been started.
+func (s *Server) HandleShutdownSignal(drainTimeout time.Duration) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	sig := <-sigc
+
+	fmt.Printf("Received %s, draining connections (up to %s)...\n", sig, drainTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		fmt.Printf("Graceful shutdown didn't finish cleanly (%s), closing now\n", err)
+		s.Close()
+	}
+}
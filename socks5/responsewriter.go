@@ -0,0 +1,63 @@
+package socks5
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// hijackableResponseWriter adapts a raw net.Conn to http.ResponseWriter +
+// http.Hijacker, so handlers downstream (namely httpconnect) that expect to
+// hijack the client connection can do so even though the request didn't
+// arrive via net/http's own server loop.
+//
+// It also doubles as the one place the SOCKS5 reply for a CONNECT attempt
+// gets sent: onHijack fires (at most once) when the tunnel is actually
+// established, and onFailure fires (at most once) if the chain instead
+// reports an error via WriteHeader, so the client only ever hears
+// "succeeded" once it's true.
+type hijackableResponseWriter struct {
+	conn      net.Conn
+	header    http.Header
+	status    int
+	onHijack  func()
+	onFailure func()
+	replied   bool
+}
+
+func (w *hijackableResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// Write discards anything written before a SOCKS5 reply has gone out, and
+// anything written after a failure reply (e.g. an HTTP error body from
+// http.Error upstream, which calls WriteHeader first): the client is a raw
+// SOCKS5 tunnel, not an HTTP client, so there's nowhere sensible to put that
+// text. Otherwise Write goes to the raw conn as normal.
+func (w *hijackableResponseWriter) Write(b []byte) (int, error) {
+	if !w.replied || (w.status != 0 && w.status != http.StatusOK) {
+		return len(b), nil
+	}
+	return w.conn.Write(b)
+}
+
+func (w *hijackableResponseWriter) WriteHeader(status int) {
+	w.status = status
+	if !w.replied && status != http.StatusOK {
+		w.replied = true
+		if w.onFailure != nil {
+			w.onFailure()
+		}
+	}
+}
+
+func (w *hijackableResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if !w.replied {
+		w.replied = true
+		if w.onHijack != nil {
+			w.onHijack()
+		}
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn))
+	return w.conn, rw, nil
+}
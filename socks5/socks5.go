@@ -0,0 +1,299 @@
+// Package socks5 implements enough of RFC 1928 (SOCKS5) to accept CONNECT
+// clients: auth negotiation, the CONNECT command, and handing the resolved
+// target off as a synthesized CONNECT request dispatched through the same
+// http.Handler chain HTTP clients go through (see httpconnect), so token/
+// device filtering, connection limits, and measured accounting continue to
+// apply uniformly across protocols.
+package socks5
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"../devicefilter"
+	"../httpconnect"
+	"../tokenfilter"
+)
+
+const (
+	version5 = 0x05
+
+	authNone         = 0x00
+	authUserPass     = 0x02
+	authNoAcceptable = 0xFF
+
+	cmdConnect = 0x01
+
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+
+	replySucceeded      = 0x00
+	replyGeneralFailure = 0x01
+	replyCmdNotSupported = 0x07
+)
+
+type optSetter func(h *Handler) error
+
+// Option configures a Handler created by New.
+type Option optSetter
+
+// Auth requires SOCKS5 username/password authentication (RFC 1929) with
+// the given credentials, instead of accepting clients with no auth at all.
+func Auth(username, password string) Option {
+	return func(h *Handler) error {
+		h.username = username
+		h.password = password
+		return nil
+	}
+}
+
+// Token sets the value threaded into tokenfilter.Header on every synthesized
+// CONNECT request, so tokenfilter accepts SOCKS5-dispatched requests the
+// same way it does HTTP ones. It's a fallback used only when Auth wasn't
+// configured (in which case the negotiated username is used instead, so
+// tokenfilter/devicefilter see the caller that actually authenticated).
+func Token(token string) Option {
+	return func(h *Handler) error {
+		h.token = token
+		return nil
+	}
+}
+
+// Handler accepts SOCKS5 connections and dispatches each CONNECT target to
+// next.
+type Handler struct {
+	next               http.Handler
+	username, password string
+	token              string
+}
+
+// New creates a Handler.
+func New(next http.Handler, setters ...Option) (*Handler, error) {
+	h := &Handler{next: next}
+	for _, s := range setters {
+		if err := s(h); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// Serve accepts SOCKS5 connections on listener until it's closed or
+// Accept returns an error.
+func (h *Handler) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go h.handleConn(conn)
+	}
+}
+
+func (h *Handler) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	if err := h.negotiateAuth(r, conn); err != nil {
+		return
+	}
+
+	target, err := readConnectRequest(r)
+	if err != nil {
+		writeReply(conn, replyGeneralFailure)
+		return
+	}
+
+	h.dispatch(conn, target)
+}
+
+// negotiateAuth performs the SOCKS5 method negotiation (RFC 1928 section
+// 3), picking username/password auth when configured via Auth and no-auth
+// otherwise.
+func (h *Handler) negotiateAuth(r *bufio.Reader, conn net.Conn) error {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return err
+	}
+	if hdr[0] != version5 {
+		return errors.New("socks5: unsupported version")
+	}
+
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return err
+	}
+
+	want := byte(authNone)
+	if h.username != "" {
+		want = authUserPass
+	}
+
+	offered := false
+	for _, m := range methods {
+		if m == want {
+			offered = true
+			break
+		}
+	}
+	if !offered {
+		conn.Write([]byte{version5, authNoAcceptable})
+		return errors.New("socks5: no acceptable auth method")
+	}
+	if _, err := conn.Write([]byte{version5, want}); err != nil {
+		return err
+	}
+
+	if want == authUserPass {
+		return h.negotiateUserPass(r, conn)
+	}
+	return nil
+}
+
+// negotiateUserPass implements the username/password subnegotiation from
+// RFC 1929.
+func (h *Handler) negotiateUserPass(r *bufio.Reader, conn net.Conn) error {
+	verAndUlen := make([]byte, 2)
+	if _, err := io.ReadFull(r, verAndUlen); err != nil {
+		return err
+	}
+	username := make([]byte, verAndUlen[1])
+	if _, err := io.ReadFull(r, username); err != nil {
+		return err
+	}
+
+	plen := make([]byte, 1)
+	if _, err := io.ReadFull(r, plen); err != nil {
+		return err
+	}
+	password := make([]byte, plen[0])
+	if _, err := io.ReadFull(r, password); err != nil {
+		return err
+	}
+
+	if string(username) != h.username || string(password) != h.password {
+		conn.Write([]byte{0x01, 0x01})
+		return errors.New("socks5: authentication failed")
+	}
+	_, err := conn.Write([]byte{0x01, 0x00})
+	return err
+}
+
+// readConnectRequest reads a SOCKS5 request (RFC 1928 section 4) and
+// returns its target as a "host:port" string. Only the CONNECT command is
+// supported; BIND and UDP ASSOCIATE are rejected.
+func readConnectRequest(r *bufio.Reader) (string, error) {
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return "", err
+	}
+	if hdr[0] != version5 {
+		return "", errors.New("socks5: unsupported version")
+	}
+	if hdr[1] != cmdConnect {
+		return "", errors.New("socks5: unsupported command")
+	}
+
+	var host string
+	switch hdr[3] {
+	case atypIPv4:
+		addr := make([]byte, 4)
+		if _, err := io.ReadFull(r, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	case atypIPv6:
+		addr := make([]byte, 16)
+		if _, err := io.ReadFull(r, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	case atypDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(r, l); err != nil {
+			return "", err
+		}
+		domain := make([]byte, l[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", errors.New("socks5: unsupported address type")
+	}
+
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(r, portBytes); err != nil {
+		return "", err
+	}
+	port := binary.BigEndian.Uint16(portBytes)
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+// writeReply writes a SOCKS5 reply with a canned BND.ADDR/BND.PORT of
+// 0.0.0.0:0, which is acceptable for CONNECT replies since most clients
+// ignore the bound address.
+func writeReply(conn net.Conn, reply byte) error {
+	_, err := conn.Write([]byte{version5, reply, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// dispatch synthesizes a CONNECT request for target and runs it through
+// next, using a ResponseWriter that can be hijacked back down to conn so
+// httpconnect's tunnel splicing works unmodified.
+//
+// The SOCKS5 reply is sent from inside the ResponseWriter itself: a
+// success reply goes out the moment next hijacks the connection (meaning
+// the dial succeeded and the tunnel is about to start), and a failure
+// reply goes out if next instead writes a non-200 status (meaning the
+// dial failed or a filter in the chain rejected the request). Sending the
+// SOCKS5 reply eagerly, before next has actually run, would tell the
+// client "tunnel established" even when it wasn't — see writeReply's
+// callers here for the one and only place that reply is written.
+func (h *Handler) dispatch(conn net.Conn, target string) {
+	req := &http.Request{
+		Method:     http.MethodConnect,
+		URL:        &url.URL{Opaque: target},
+		Host:       target,
+		RemoteAddr: conn.RemoteAddr().String(),
+		Header:     make(http.Header),
+	}
+	req.Header.Set(httpconnect.NoBannerHeader, "1")
+
+	// Identify the caller to tokenfilter/devicefilter: prefer the SOCKS5-
+	// negotiated username (the identity that was actually authenticated),
+	// falling back to the server-wide token configured via Token. Both
+	// headers need to be set here, not just tokenfilter.Header: firstHandler
+	// is devicefilter wrapping tokenFilter (see server.go's NewServer), and
+	// devicefilter 404s any request missing its own identity header before
+	// tokenfilter ever sees it.
+	identity := h.username
+	if identity == "" {
+		identity = h.token
+	}
+	if identity != "" {
+		req.Header.Set(tokenfilter.Header, identity)
+		req.Header.Set(devicefilter.Header, identity)
+	}
+
+	w := &hijackableResponseWriter{
+		conn:   conn,
+		header: make(http.Header),
+		onHijack: func() {
+			writeReply(conn, replySucceeded)
+		},
+		onFailure: func() {
+			writeReply(conn, replyGeneralFailure)
+		},
+	}
+	h.next.ServeHTTP(w, req)
+}
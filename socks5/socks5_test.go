@@ -0,0 +1,97 @@
+package socks5
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"../devicefilter"
+	"../tokenfilter"
+)
+
+// TestDispatchThreadsIdentityThroughFilterChain drives a synthesized CONNECT
+// request through the same devicefilter->tokenfilter chain firstHandler uses
+// in production, and asserts the client actually gets a SOCKS5 success
+// reply. Without both tokenfilter.Header and devicefilter.Header set,
+// devicefilter 404s the request before tokenfilter ever sees it, and this
+// test would see a failure reply instead.
+func TestDispatchThreadsIdentityThroughFilterChain(t *testing.T) {
+	final := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			t.Fatal("response writer isn't a Hijacker")
+		}
+		if _, _, err := hijacker.Hijack(); err != nil {
+			t.Fatalf("hijack: %v", err)
+		}
+	})
+
+	tokenFilter, err := tokenfilter.New(final, tokenfilter.TokenSetter("secret"))
+	if err != nil {
+		t.Fatalf("tokenfilter.New: %v", err)
+	}
+	deviceFilter, err := devicefilter.New(tokenFilter)
+	if err != nil {
+		t.Fatalf("devicefilter.New: %v", err)
+	}
+
+	h, err := New(deviceFilter, Token("secret"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	go h.dispatch(serverConn, "example.com:443")
+
+	clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(clientConn, reply); err != nil {
+		t.Fatalf("reading SOCKS5 reply: %v", err)
+	}
+	if reply[1] != replySucceeded {
+		t.Fatalf("got SOCKS5 reply code %#x, want replySucceeded (identity wasn't threaded through the filter chain)", reply[1])
+	}
+}
+
+// TestDispatchFailsWithoutIdentity covers the companion case: no Token and
+// no Auth configured means no identity to thread through, so the filter chain
+// (here devicefilter, which 404s the missing UID before tokenfilter sees the
+// request) rejects it with a SOCKS5 failure reply rather than silently
+// tunneling an unauthenticated caller.
+func TestDispatchFailsWithoutIdentity(t *testing.T) {
+	final := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Fatal("final handler should not be reached when the filter chain rejects the request")
+	})
+
+	tokenFilter, err := tokenfilter.New(final, tokenfilter.TokenSetter("secret"))
+	if err != nil {
+		t.Fatalf("tokenfilter.New: %v", err)
+	}
+	deviceFilter, err := devicefilter.New(tokenFilter)
+	if err != nil {
+		t.Fatalf("devicefilter.New: %v", err)
+	}
+
+	h, err := New(deviceFilter)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	go h.dispatch(serverConn, "example.com:443")
+
+	clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(clientConn, reply); err != nil {
+		t.Fatalf("reading SOCKS5 reply: %v", err)
+	}
+	if reply[1] != replyGeneralFailure {
+		t.Fatalf("got SOCKS5 reply code %#x, want replyGeneralFailure", reply[1])
+	}
+}
@@ -0,0 +1,112 @@
+// Package tokenfilter implements the middleware that gates access behind a
+// shared token: requests without a matching token get a 404, and the
+// header carrying the token is stripped before the request continues.
+package tokenfilter
+
+import (
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"../utils"
+)
+
+// Header is the request header tokenfilter reads the token from.
+const Header = "X-Lantern-Auth-Token"
+
+// EnvToken is the environment variable ReloadTokens re-reads the token
+// from, letting an operator rotate it without a restart.
+const EnvToken = "HTTPPROXY_TOKEN"
+
+type optSetter func(f *Filter) error
+
+// Option configures a Filter created by New.
+type Option optSetter
+
+// TokenSetter sets the token requests must carry. An empty token disables
+// the check (every request is allowed through).
+func TokenSetter(token string) Option {
+	return func(f *Filter) error {
+		f.setToken(token)
+		return nil
+	}
+}
+
+// Logger sets the logger used to report denied requests.
+func Logger(l *utils.TimeLogger) Option {
+	return func(f *Filter) error {
+		f.log = l
+		return nil
+	}
+}
+
+// Filter returns a 404 to requests without the proper token.  Removes the
+// header before continuing.
+type Filter struct {
+	next http.Handler
+	log  *utils.TimeLogger
+
+	mu    sync.RWMutex
+	token string
+
+	allowed uint64
+	denied  uint64
+}
+
+// New creates a Filter.
+func New(next http.Handler, setters ...Option) (*Filter, error) {
+	f := &Filter{next: next}
+	for _, s := range setters {
+		if err := s(f); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *Filter) setToken(token string) {
+	f.mu.Lock()
+	f.token = token
+	f.mu.Unlock()
+}
+
+func (f *Filter) currentToken() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.token
+}
+
+func (f *Filter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	got := req.Header.Get(Header)
+	req.Header.Del(Header)
+
+	if want := f.currentToken(); want != "" && got != want {
+		atomic.AddUint64(&f.denied, 1)
+		if f.log != nil {
+			f.log.Printf("tokenfilter: denied %s", req.RemoteAddr)
+		}
+		http.NotFound(w, req)
+		return
+	}
+
+	atomic.AddUint64(&f.allowed, 1)
+	f.next.ServeHTTP(w, req)
+}
+
+// ReloadTokens implements admin.TokenReloader, re-reading the token from
+// EnvToken so an operator can rotate it without a restart.
+func (f *Filter) ReloadTokens() error {
+	if v := os.Getenv(EnvToken); v != "" {
+		f.setToken(v)
+	}
+	return nil
+}
+
+// Counters implements admin.MetricsSource.
+func (f *Filter) Counters() map[string]float64 {
+	return map[string]float64{
+		"httpproxy_tokenfilter_allowed_total": float64(atomic.LoadUint64(&f.allowed)),
+		"httpproxy_tokenfilter_denied_total":  float64(atomic.LoadUint64(&f.denied)),
+	}
+}